@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// Action IDs of the buttons sendBlocks/buildStandupBlocks attaches to every
+// user's standup entry.
+const (
+	standupEditActionID    = "standup_edit"
+	standupRemoveActionID  = "standup_remove"
+	standupRefreshActionID = "standup_refresh"
+
+	// standupEditCallbackID identifies the modal opened by standupEditActionID,
+	// so handleInteraction can tell a standup edit apart from any other view
+	// submission.
+	standupEditCallbackID  = "standup_edit_view"
+	standupEditBlockID     = "standup_text"
+	standupEditActionBlock = "standup_text_input"
+)
+
+// buildStandupBlocks renders cstandup as Block Kit: a header, then for every
+// user a section with their latest update (a plain <@userID> mention, now
+// that we no longer need the zero-width-character trick to avoid notifying
+// people every time the status is refreshed), a context block with a
+// humanized timestamp, and an actions block letting that user edit or
+// remove their own entry, or anyone refresh the whole status.
+func (a arriba) buildStandupBlocks(target standupTarget, cstandup channelStandup) []slack.Block {
+	blocks := []slack.Block{
+		slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, "¡Ándale! ¡Ándale! here's the standup status :tada:", false, false)),
+	}
+	if len(cstandup) == 0 {
+		blocks = append(blocks, slack.NewSectionBlock(
+			slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("No standup messages found\nType a message starting with *@%s* to record your standup message", a.botName), false, false),
+			nil, nil,
+		))
+		return blocks
+	}
+	for _, userID := range cstandup.getKeysByTimestamp() {
+		msg := cstandup[userID]
+		blockID := standupBlockID(target, userID)
+		blocks = append(blocks,
+			slack.NewSectionBlock(
+				slack.NewTextBlockObject(slack.MarkdownType, fmt.Sprintf("<@%s>: %s", userID, msg.text), false, false),
+				nil, nil,
+			),
+			slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, "_"+humanize.Time(msg.ts)+"_", false, false)),
+			slack.NewActionBlock(blockID,
+				slack.NewButtonBlockElement(standupEditActionID, userID, slack.NewTextBlockObject(slack.PlainTextType, "Edit my standup", false, false)),
+				slack.NewButtonBlockElement(standupRemoveActionID, userID, slack.NewTextBlockObject(slack.PlainTextType, "Remove my standup", false, false)),
+				slack.NewButtonBlockElement(standupRefreshActionID, userID, slack.NewTextBlockObject(slack.PlainTextType, "Refresh", false, false)),
+			),
+		)
+	}
+	return blocks
+}
+
+// standupBlockID packs target's scope into an action block's BlockID, so
+// handleStandupAction can recover which standup a button click belongs to
+// without any extra state.
+func standupBlockID(target standupTarget, userID string) string {
+	return target.scopeID + "|" + userID
+}
+
+// parseStandupBlockID is the inverse of standupBlockID.
+func parseStandupBlockID(blockID string) (target standupTarget, userID string, ok bool) {
+	idx := strings.LastIndex(blockID, "|")
+	if idx < 0 {
+		return standupTarget{}, "", false
+	}
+	return decodeScope(blockID[:idx]), blockID[idx+1:], true
+}
+
+// decodeScope rebuilds a standupTarget from a scopeID (either a bare
+// channelID or channelID:threadTimestamp, see newStandupTarget).
+func decodeScope(scopeID string) standupTarget {
+	if idx := strings.Index(scopeID, ":"); idx >= 0 {
+		return standupTarget{scopeID: scopeID, channelID: scopeID[:idx], threadTimestamp: scopeID[idx+1:]}
+	}
+	return standupTarget{scopeID: scopeID, channelID: scopeID}
+}
+
+// sendBlocks posts blocks to target, the Block Kit equivalent of send.
+func (a arriba) sendBlocks(target standupTarget, blocks []slack.Block) {
+	options := []slack.MsgOption{slack.MsgOptionBlocks(blocks...)}
+	if target.threadTimestamp != "" {
+		options = append(options, slack.MsgOptionTS(target.threadTimestamp))
+	}
+	if _, _, err := a.client.PostMessage(target.channelID, options...); err != nil {
+		logrus.Errorf("Can't post message to channel %s: %s", target.channelID, err)
+	}
+}
+
+// updateStatusMessage re-renders target's current standup status over the
+// message at channelID/timestamp, e.g. after a button click changed it.
+func (a arriba) updateStatusMessage(target standupTarget, channelID, timestamp string) {
+	a.mu.Lock()
+	blocks := a.buildStandupBlocks(target, a.standups[target.scopeID])
+	a.mu.Unlock()
+	if _, _, _, err := a.client.UpdateMessage(channelID, timestamp, slack.MsgOptionBlocks(blocks...)); err != nil {
+		logrus.Errorf("Can't update standup status message in channel %s: %s", channelID, err)
+	}
+}
+
+// standupEditMetadata is carried as a modal's PrivateMetadata so
+// handleStandupEditSubmission can apply the edit and refresh the right
+// status message once the user submits it.
+type standupEditMetadata struct {
+	ScopeID   string `json:"scope_id"`
+	ChannelID string `json:"channel_id"`
+	Timestamp string `json:"timestamp"`
+	UserID    string `json:"user_id"`
+}
+
+// handleStandupAction implements the edit/remove/refresh buttons attached to
+// a Block Kit standup status message.
+func (a arriba) handleStandupAction(payload slack.InteractionCallback) {
+	if len(payload.ActionCallback.BlockActions) == 0 {
+		return
+	}
+	action := payload.ActionCallback.BlockActions[0]
+	target, userID, ok := parseStandupBlockID(action.BlockID)
+	if !ok {
+		return
+	}
+
+	switch action.ActionID {
+	case standupRefreshActionID:
+		a.updateStatusMessage(target, payload.Channel.ID, payload.Message.Timestamp)
+	case standupRemoveActionID:
+		if payload.User.ID != userID {
+			a.ephemeral(payload.Channel.ID, payload.User.ID, "You can only remove your own standup entry.")
+			return
+		}
+		a.mu.Lock()
+		delete(a.standups[target.scopeID], userID)
+		err := a.store.Delete(target.scopeID, userID)
+		a.mu.Unlock()
+		if err != nil {
+			logrus.Errorf("Can't delete standup for user %s in scope %s: %s", userID, target.scopeID, err)
+		}
+		a.updateStatusMessage(target, payload.Channel.ID, payload.Message.Timestamp)
+	case standupEditActionID:
+		if payload.User.ID != userID {
+			a.ephemeral(payload.Channel.ID, payload.User.ID, "You can only edit your own standup entry.")
+			return
+		}
+		a.openEditModal(payload, target, userID)
+	}
+}
+
+// ephemeral is a thin wrapper around chat.postEphemeral, used for the
+// "that's not your entry" rejection.
+func (a arriba) ephemeral(channelID, userID, text string) {
+	if _, err := a.client.PostEphemeral(channelID, userID, slack.MsgOptionText(text, false)); err != nil {
+		logrus.Errorf("Can't send ephemeral message to user %s: %s", userID, err)
+	}
+}
+
+// openEditModal opens a modal, prefilled with userID's current standup text,
+// that lets them revise it in place instead of posting a whole new message.
+func (a arriba) openEditModal(payload slack.InteractionCallback, target standupTarget, userID string) {
+	a.mu.Lock()
+	current := a.standups[target.scopeID][userID].text
+	a.mu.Unlock()
+
+	meta, err := json.Marshal(standupEditMetadata{
+		ScopeID:   target.scopeID,
+		ChannelID: payload.Channel.ID,
+		Timestamp: payload.Message.Timestamp,
+		UserID:    userID,
+	})
+	if err != nil {
+		logrus.Errorf("Can't encode edit metadata for user %s: %s", userID, err)
+		return
+	}
+
+	input := slack.NewPlainTextInputBlockElement(nil, standupEditActionBlock)
+	input.InitialValue = current
+	input.Multiline = true
+
+	view := slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		CallbackID:      standupEditCallbackID,
+		PrivateMetadata: string(meta),
+		Title:           slack.NewTextBlockObject(slack.PlainTextType, "Edit standup", false, false),
+		Submit:          slack.NewTextBlockObject(slack.PlainTextType, "Save", false, false),
+		Close:           slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+		Blocks: slack.Blocks{
+			BlockSet: []slack.Block{
+				slack.NewInputBlock(standupEditBlockID,
+					slack.NewTextBlockObject(slack.PlainTextType, "Standup update", false, false),
+					nil,
+					input,
+				),
+			},
+		},
+	}
+	if _, err := a.client.OpenView(payload.TriggerID, view); err != nil {
+		logrus.Errorf("Can't open edit modal for user %s: %s", userID, err)
+	}
+}
+
+// handleStandupEditSubmission implements eventHandler's view_submission
+// side: it applies the edited text from openEditModal and refreshes the
+// status message it was opened from.
+func (a arriba) handleStandupEditSubmission(payload slack.InteractionCallback) {
+	if payload.View.CallbackID != standupEditCallbackID {
+		return
+	}
+	var meta standupEditMetadata
+	if err := json.Unmarshal([]byte(payload.View.PrivateMetadata), &meta); err != nil {
+		logrus.Errorf("Can't decode edit metadata: %s", err)
+		return
+	}
+	text := strings.TrimSpace(payload.View.State.Values[standupEditBlockID][standupEditActionBlock].Value)
+	if text == "" {
+		return
+	}
+
+	target := decodeScope(meta.ScopeID)
+	a.updateLastStandup(target, meta.UserID, standupMsg{ts: time.Now().UTC(), text: text})
+	a.updateStatusMessage(target, meta.ChannelID, meta.Timestamp)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// standupsBucket is the single bolt bucket arriba keeps its standups in,
+// keyed by "<channelID>/<userID>". This is the same kind of thin bolt
+// wrapper aocbot/helperbot use (there it's called boltease); arriba's needs
+// are small enough to inline directly instead of pulling in the dependency.
+var standupsBucket = []byte("standups")
+
+// boltStore is a StandupStore backed by a BoltDB file on disk.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// newBoltStore opens (creating if necessary) a BoltDB database at path.
+func newBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("can't open bolt store at %s: %s", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(standupsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func boltKey(channelID, userID string) []byte {
+	return []byte(channelID + "/" + userID)
+}
+
+// boltStandupMsg is the JSON-encoded value stored for each bolt key; it
+// carries the channel/user back out so LoadAll can rebuild the standups map.
+type boltStandupMsg struct {
+	ChannelID string    `json:"channel_id"`
+	UserID    string    `json:"user_id"`
+	Ts        time.Time `json:"ts"`
+	Text      string    `json:"text"`
+}
+
+func (s *boltStore) LoadAll() (standups, error) {
+	result := make(standups)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(standupsBucket).ForEach(func(k, v []byte) error {
+			var stored boltStandupMsg
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return fmt.Errorf("can't decode standup %s: %s", k, err)
+			}
+			if _, ok := result[stored.ChannelID]; !ok {
+				result[stored.ChannelID] = make(channelStandup)
+			}
+			result[stored.ChannelID][stored.UserID] = standupMsg{ts: stored.Ts, text: stored.Text}
+			return nil
+		})
+	})
+	return result, err
+}
+
+func (s *boltStore) Upsert(channelID, userID string, msg standupMsg) error {
+	data, err := json.Marshal(boltStandupMsg{ChannelID: channelID, UserID: userID, Ts: msg.ts, Text: msg.text})
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(standupsBucket).Put(boltKey(channelID, userID), data)
+	})
+}
+
+func (s *boltStore) Delete(channelID, userID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(standupsBucket).Delete(boltKey(channelID, userID))
+	})
+}
+
+func (s *boltStore) PurgeOlderThan(t time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(standupsBucket)
+		var stale [][]byte
+		err := b.ForEach(func(k, v []byte) error {
+			var stored boltStandupMsg
+			if err := json.Unmarshal(v, &stored); err != nil {
+				return err
+			}
+			if stored.Ts.Before(t) {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"gopkg.in/yaml.v2"
+)
+
+// scheduleConfig is the -config YAML shape: a list of per-channel cron
+// schedules loaded at startup.
+//
+//	schedules:
+//	  - channel: C0123456
+//	    reminder: "0 14 * * MON-FRI"
+//	    digest: "0 17 * * MON-FRI"
+type scheduleConfig struct {
+	Schedules []struct {
+		Channel  string `yaml:"channel"`
+		Reminder string `yaml:"reminder"`
+		Digest   string `yaml:"digest"`
+	} `yaml:"schedules"`
+}
+
+// loadSchedules reads a YAML config file and installs every schedule it
+// describes into s. A blank path is a no-op, so -config is optional.
+func loadSchedules(ctx context.Context, path string, s *scheduler) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("can't read config %s: %s", path, err)
+	}
+	var cfg scheduleConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("can't parse config %s: %s", path, err)
+	}
+	for _, entry := range cfg.Schedules {
+		if err := s.addSchedule(ctx, entry.Channel, entry.Reminder, entry.Digest); err != nil {
+			return fmt.Errorf("can't add schedule for channel %s: %s", entry.Channel, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+)
+
+// standupChannelChoiceCallbackID prefixes the CallbackID of the interactive
+// message arriba sends when a DMed standup could apply to more than one
+// channel; the rest of the ID is the DM's own timestamp, used to look the
+// pending standup back up in handleInteraction.
+const standupChannelChoiceCallbackID = "standup_channel_choice:"
+
+// pendingDM is a standup message received via DM whose target channel is
+// still being chosen by the user through an interactive message.
+type pendingDM struct {
+	userID string
+	msg    standupMsg
+}
+
+// handleDirectMessage records a standup DMed straight to the bot, without
+// requiring the usual <@bot> prefix. It's recorded against a.homeChannel if
+// one is configured; otherwise the user is asked which of their channels it
+// should go to.
+func (a arriba) handleDirectMessage(msg inboundMessage) {
+	if msg.subtype != "" {
+		return
+	}
+	text := strings.TrimSpace(msg.text)
+	if text == "" {
+		return
+	}
+	ts, err := parseSlackTimeStamp(msg.ts)
+	if err != nil {
+		return
+	}
+	smsg := standupMsg{ts: ts, text: text}
+
+	if a.homeChannel != "" {
+		a.updateLastStandup(newStandupTarget(a.homeChannel, ""), msg.user, smsg)
+		return
+	}
+
+	channels, err := userConversations(context.Background(), a.client, msg.user)
+	if err != nil {
+		logrus.Errorf("Can't list channels for user %s: %s", msg.user, err)
+		return
+	}
+
+	switch len(channels) {
+	case 0:
+		a.send(newStandupTarget(msg.channel, ""), "You're not a member of any channel I can record a standup in.")
+	case 1:
+		a.updateLastStandup(newStandupTarget(channels[0].ID, ""), msg.user, smsg)
+	default:
+		a.promptChannelChoice(msg, smsg, channels)
+	}
+}
+
+// promptChannelChoice asks the user, via an interactive message, which of
+// their channels a DMed standup should be recorded against.
+func (a arriba) promptChannelChoice(msg inboundMessage, smsg standupMsg, channels []slack.Channel) {
+	a.mu.Lock()
+	a.pendingDM[msg.ts] = pendingDM{userID: msg.user, msg: smsg}
+	a.mu.Unlock()
+
+	actions := make([]slack.AttachmentAction, 0, len(channels))
+	for _, c := range channels {
+		actions = append(actions, slack.AttachmentAction{
+			Name:  "channel",
+			Text:  "#" + c.Name,
+			Type:  "button",
+			Value: c.ID,
+		})
+	}
+	attachment := slack.Attachment{
+		CallbackID: standupChannelChoiceCallbackID + msg.ts,
+		Text:       "Which channel should I record this standup against?",
+		Actions:    actions,
+	}
+	if _, _, err := a.client.PostMessage(msg.channel, slack.MsgOptionAttachments(attachment)); err != nil {
+		logrus.Errorf("Can't prompt channel choice for user %s: %s", msg.user, err)
+	}
+}
+
+// handleInteraction implements eventHandler, dispatching every interactive
+// payload arriba can receive: the classic attachment buttons used to pick a
+// channel for a DMed standup, the Block Kit buttons on a status message, and
+// the edit modal's submission.
+func (a arriba) handleInteraction(payload slack.InteractionCallback) {
+	switch payload.Type {
+	case slack.InteractionTypeInteractionMessage:
+		a.handleChannelChoice(payload)
+	case slack.InteractionTypeBlockActions:
+		a.handleStandupAction(payload)
+	case slack.InteractionTypeViewSubmission:
+		a.handleStandupEditSubmission(payload)
+	}
+}
+
+// handleChannelChoice applies a pending DMed standup to the channel the user
+// picked via promptChannelChoice.
+func (a arriba) handleChannelChoice(payload slack.InteractionCallback) {
+	if !strings.HasPrefix(payload.CallbackID, standupChannelChoiceCallbackID) {
+		return
+	}
+	dmTS := strings.TrimPrefix(payload.CallbackID, standupChannelChoiceCallbackID)
+	a.mu.Lock()
+	pending, ok := a.pendingDM[dmTS]
+	delete(a.pendingDM, dmTS)
+	a.mu.Unlock()
+	if !ok || len(payload.ActionCallback.AttachmentActions) == 0 {
+		return
+	}
+	channelID := payload.ActionCallback.AttachmentActions[0].Value
+	a.updateLastStandup(newStandupTarget(channelID, ""), pending.userID, pending.msg)
+}
+
+// userConversations lists the public and private channels userID is a
+// member of, paginating through conversations.list the same way
+// listConversations does.
+func userConversations(ctx context.Context, client *slack.Client, userID string) ([]slack.Channel, error) {
+	var result []slack.Channel
+	cursor := ""
+	for {
+		var chans []slack.Channel
+		var nextCursor string
+		err := withRateLimit(ctx, func() error {
+			var err error
+			chans, nextCursor, err = client.GetConversationsForUserContext(ctx, &slack.GetConversationsForUserParameters{
+				UserID: userID,
+				Types:  []string{"public_channel", "private_channel"},
+				Cursor: cursor,
+				Limit:  200,
+			})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, chans...)
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+	return result, nil
+}
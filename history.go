@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// historyPageSize is the largest page conversations.history will hand back
+// per call; Slack caps this well below the 1000 the old channels.history
+// allowed.
+const historyPageSize = 200
+
+// fetchHistory streams every message of conversation c newer than since,
+// most recent first, through the returned channel. It follows
+// conversations.history's cursor-based pagination and honors Slack's rate
+// limits via withRateLimit. Both channels are closed once history is
+// exhausted, an error occurs, or ctx is cancelled; the error channel always
+// receives exactly one value (nil on success) before closing.
+func fetchHistory(ctx context.Context, client *slack.Client, c conversation, since time.Time) (<-chan slack.Message, <-chan error) {
+	messages := make(chan slack.Message)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(messages)
+
+		cursor := ""
+		for {
+			var history *slack.GetConversationHistoryResponse
+			err := withRateLimit(ctx, func() error {
+				var err error
+				history, err = client.GetConversationHistoryContext(ctx, &slack.GetConversationHistoryParameters{
+					ChannelID: c.getID(),
+					Oldest:    fmt.Sprintf("%d", since.Unix()),
+					Cursor:    cursor,
+					Limit:     historyPageSize,
+				})
+				return err
+			})
+			if err != nil {
+				errs <- err
+				close(errs)
+				return
+			}
+
+			for _, msg := range history.Messages {
+				select {
+				case messages <- msg:
+				case <-ctx.Done():
+					errs <- ctx.Err()
+					close(errs)
+					return
+				}
+			}
+
+			if !history.HasMore || history.ResponseMetaData.NextCursor == "" {
+				break
+			}
+			cursor = history.ResponseMetaData.NextCursor
+		}
+		errs <- nil
+		close(errs)
+	}()
+
+	return messages, errs
+}
@@ -1,16 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"regexp"
 	"sort"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/Sirupsen/logrus"
-	humanize "github.com/dustin/go-humanize"
-	"github.com/nlopes/slack"
+	"github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
 )
 
 const extractMsgGroupName = "msg"
@@ -40,6 +42,18 @@ func (s sortableChannelStandup) Less(i, j int) bool {
 	return s.cs[s.keys[i]].ts.After(s.cs[s.keys[j]].ts)
 }
 
+// latestTimestamp returns the timestamp of the most recent message in cs, or
+// the zero time if cs is empty.
+func (cs channelStandup) latestTimestamp() time.Time {
+	var latest time.Time
+	for _, msg := range cs {
+		if msg.ts.After(latest) {
+			latest = msg.ts
+		}
+	}
+	return latest
+}
+
 // getKeysByTimestamp returns the userIDs of the standup ordered by their message timestamp (newer first).
 func (cs channelStandup) getKeysByTimestamp() []string {
 	keys := make([]string, 0, len(cs))
@@ -57,49 +71,59 @@ func (cs channelStandup) getKeysByTimestamp() []string {
 // standups contains the channelStandup of all Slack channels known to the bot.
 type standups map[string]channelStandup
 
-// conversation is a generic way to access the IDs, Names and history of both
-// slack.Channel and slack.Group. Unfortunately nlopes/slack doesn't expose the
-// underlying common type (groupConversation) and we cannot define methods for
-// non-local types, which would allow to make things much cleaner ...
-type conversation interface {
-	getID() string
-	getName() string
-	getHistory(*slack.RTM, slack.HistoryParameters) (*slack.History, error)
+// conversation wraps a slack.Channel, which already distinguishes public
+// channels, private channels, MPIMs and IMs via IsChannel/IsGroup/IsMpIM/IsIM.
+// It used to be an interface implemented separately by channel and group
+// wrappers, back when we relied on the now-deprecated channels.history and
+// groups.history endpoints; the conversations.* API collapses both into one.
+type conversation struct {
+	slack.Channel
 }
 
-type channel slack.Channel
-
-func (c channel) getID() string   { return c.ID }
-func (c channel) getName() string { return c.Name }
-func (c channel) getHistory(rtm *slack.RTM, params slack.HistoryParameters) (*slack.History, error) {
-	return rtm.GetChannelHistory(c.getID(), params)
-}
-
-type group slack.Group
-
-func (g group) getID() string   { return g.ID }
-func (g group) getName() string { return g.Name }
-func (g group) getHistory(rtm *slack.RTM, params slack.HistoryParameters) (*slack.History, error) {
-	return rtm.GetGroupHistory(g.getID(), params)
-}
+func (c conversation) getID() string   { return c.ID }
+func (c conversation) getName() string { return c.Name }
 
 type arriba struct {
-	rtm              *slack.RTM
+	client           *slack.Client
 	botID            string
 	botName          string
 	extractMsgRE     *regexp.Regexp
 	historyDaysLimit int
-	standups         standups
+	// mu guards standups (and the store writes that accompany them) and
+	// pendingDM, both of which are read and written from more than one
+	// goroutine: the transport's event-handling goroutine (possibly one
+	// per HTTP request, under -transport=events), and the scheduler's own
+	// goroutine. It's a pointer so every copy of arriba (most of its
+	// methods take a value receiver) shares the same lock.
+	mu          *sync.Mutex
+	standups    standups
+	store       StandupStore
+	homeChannel string
+	pendingDM   map[string]pendingDM
+	scheduler   *scheduler
 }
 
-func newArriba(rtm *slack.RTM, historyDaysLimit int) arriba {
+// newArriba builds an arriba. If homeChannel is non-empty, standups DMed to
+// the bot are always recorded against it; otherwise the user is asked which
+// of their channels to record against (see handleDirectMessage).
+func newArriba(client *slack.Client, historyDaysLimit int, store StandupStore, homeChannel string) arriba {
 	return arriba{
-		rtm:              rtm,
+		client:           client,
 		historyDaysLimit: historyDaysLimit,
+		mu:               &sync.Mutex{},
 		standups:         make(standups),
+		store:            store,
+		homeChannel:      homeChannel,
+		pendingDM:        make(map[string]pendingDM),
 	}
 }
 
+// oldestAllowed is the cut-off point before which standup messages are
+// considered stale and eligible for garbage collection.
+func (a arriba) oldestAllowed() time.Time {
+	return time.Now().UTC().AddDate(0, 0, -a.historyDaysLimit)
+}
+
 func parseSlackTimeStamp(ts string) (time.Time, error) {
 	var seconds, milliseconds int64
 	_, err := fmt.Sscanf(ts, "%d.%d", &seconds, &milliseconds)
@@ -110,216 +134,235 @@ func parseSlackTimeStamp(ts string) (time.Time, error) {
 	return time.Unix(seconds, milliseconds*1000), nil
 }
 
-// extractStandupMsg parses Slack messages starting with @bot-name
-func (a arriba) extractChannelStandupMsg(msg slack.Msg) (standupMsg, bool) {
-	if msg.Type != "message" || msg.SubType != "" {
+// extractStandupMsg parses Slack messages starting with @bot-name, regardless
+// of which transport (RTM, Socket Mode, Events API) delivered them.
+func (a arriba) extractStandupMsg(msg inboundMessage) (standupMsg, bool) {
+	if msg.subtype != "" {
 		return standupMsg{}, false
 	}
-	standupText := a.extractMsgRE.ReplaceAllString(msg.Text, "$"+extractMsgGroupName)
-	if len(standupText) == len(msg.Text) {
+	standupText := a.extractMsgRE.ReplaceAllString(msg.text, "$"+extractMsgGroupName)
+	if len(standupText) == len(msg.text) {
 		// Nothing was extracted
 		return standupMsg{}, false
 	}
-	ts, err := parseSlackTimeStamp(msg.Timestamp)
+	ts, err := parseSlackTimeStamp(msg.ts)
 	if err != nil {
 		return standupMsg{}, false
 	}
 	return standupMsg{ts, standupText}, true
 }
 
-func (a arriba) retrieveChannelStandup(c conversation) (channelStandup, error) {
-	params := slack.NewHistoryParameters()
-	params.Count = 1000
-	now := time.Now().UTC()
-	params.Latest = fmt.Sprintf("%d", now.Unix())
-	params.Oldest = fmt.Sprintf("%d", now.AddDate(0, 0, -a.historyDaysLimit).Unix())
+// msgToInbound adapts a slack.Msg (as returned by the history APIs) to the
+// transport-agnostic inboundMessage shape extractStandupMsg expects.
+func msgToInbound(msg slack.Msg) inboundMessage {
+	return inboundMessage{
+		channel:         msg.Channel,
+		user:            msg.User,
+		text:            msg.Text,
+		ts:              msg.Timestamp,
+		threadTimestamp: msg.ThreadTimestamp,
+		subtype:         msg.SubType,
+	}
+}
 
-	// It would be way more efficient to use slack.SearchMsgs instead
-	// of traversing the whole history, but that's not allowed for bots :(
+// retrieveChannelStandup scans the history of c for standup messages more
+// recent than since, keeping only the latest message per user. It streams
+// through fetchHistory so callers can cancel via ctx instead of waiting out
+// a full (possibly rate-limited) scan.
+func (a arriba) retrieveChannelStandup(ctx context.Context, c conversation, since time.Time) (channelStandup, error) {
 	cstandup := make(channelStandup)
-	for {
-		history, error := c.getHistory(a.rtm, params)
-		if error != nil || history == nil || len(history.Messages) == 0 {
-			return cstandup, error
-		}
-
-		logrus.Debugf(
-			"Got history chunk (from %s to %s, latest %s) for conversation %s",
-			history.Messages[len(history.Messages)-1].Msg.Timestamp,
-			history.Messages[0].Msg.Timestamp, history.Latest, c.getID())
-
-		// Messages are increasingly ordered by time, traverse them in reverse order
-		for i, _ := range history.Messages {
-			msg := history.Messages[len(history.Messages)-1-i]
-			if _, ok := cstandup[msg.User]; ok {
-				// we already have the latest standup message for this user
-				continue
-			}
-			standupMsg, ok := a.extractChannelStandupMsg(msg.Msg)
-			if ok && standupMsg.text != "" {
-				cstandup[msg.User] = standupMsg
-			}
+	messages, errs := fetchHistory(ctx, a.client, c, since)
+	for msg := range messages {
+		if _, ok := cstandup[msg.User]; ok {
+			// we already have the latest standup message for this user
+			continue
 		}
-
-		if !history.HasMore {
-			break
+		standupMsg, ok := a.extractStandupMsg(msgToInbound(msg.Msg))
+		if ok && standupMsg.text != "" {
+			cstandup[msg.User] = standupMsg
 		}
-		latestMsg := history.Messages[len(history.Messages)-1]
-		params.Latest = latestMsg.Timestamp
-		params.Inclusive = false
 	}
-	return cstandup, nil
+	return cstandup, <-errs
 }
 
-func (a arriba) retrieveStandups(conversations []conversation) {
+// retrieveStandups reconciles the store with Slack: existing standups are
+// loaded from the store, and only the gap since the last persisted message
+// is fetched from Slack history.
+func (a arriba) retrieveStandups(ctx context.Context, conversations []conversation) {
+	if err := a.store.PurgeOlderThan(a.oldestAllowed()); err != nil {
+		logrus.Errorf("Can't purge stale standups from the store: %s", err)
+	}
+	persisted, err := a.store.LoadAll()
+	if err != nil {
+		logrus.Errorf("Can't load persisted standups, falling back to a full history scan: %s", err)
+		persisted = make(standups)
+	}
 	for _, c := range conversations {
 		logrus.Infof("Retrieveing standup for conversation #%s (%s)", c.getName(), c.getID())
-		cstandup, err := a.retrieveChannelStandup(c)
+		cstandup := persisted[c.getID()]
+		if cstandup == nil {
+			cstandup = make(channelStandup)
+		}
+		since := a.oldestAllowed()
+		if latest := cstandup.latestTimestamp(); latest.After(since) {
+			since = latest
+		}
+		fresh, err := a.retrieveChannelStandup(ctx, c, since)
 		if err != nil {
 			logrus.Errorf("Can't retrieve channel standup for conversation #%s: %s", c.getName(), err)
 		}
+		for userID, msg := range fresh {
+			cstandup[userID] = msg
+			if err := a.store.Upsert(c.getID(), userID, msg); err != nil {
+				logrus.Errorf("Can't persist standup for user %s in conversation #%s: %s", userID, c.getName(), err)
+			}
+		}
+		a.mu.Lock()
 		a.standups[c.getID()] = cstandup
+		a.mu.Unlock()
 		logrus.Infof("Standup for conversation #%s (%s) updated to %#v", c.getName(), c.getID(), cstandup)
 	}
-}
 
-func (a arriba) getUserName(userID string) string {
-	info, err := a.rtm.GetUserInfo(userID)
-	userName := "id" + userID
-	if err != nil {
-		logrus.Errorf("Couldn't get user information for user %s: %s", userID, err)
-	} else {
-		userName = info.Name
+	// Thread-scoped standups are persisted under a scopeID of
+	// "channelID:threadTimestamp" (see newStandupTarget), which
+	// conversations.history never returns replies for, so the loop above
+	// never repopulates them. Load whatever the store still has for them
+	// directly instead.
+	since := a.oldestAllowed()
+	a.mu.Lock()
+	for scopeID, cstandup := range persisted {
+		if _, ok := a.standups[scopeID]; ok || !strings.Contains(scopeID, ":") {
+			continue
+		}
+		if cstandup.latestTimestamp().Before(since) {
+			continue
+		}
+		a.standups[scopeID] = cstandup
+		logrus.Infof("Standup for thread %s restored from store to %#v", scopeID, cstandup)
 	}
-	return userName
+	a.mu.Unlock()
 }
 
-func (a arriba) removeOldMessages(channelID string) {
-	cstandup, ok := a.standups[channelID]
+func (a arriba) removeOldMessages(scopeID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cstandup, ok := a.standups[scopeID]
 	if !ok {
 		return
 	}
-	oldestAllowed := time.Now().UTC().AddDate(0, 0, -a.historyDaysLimit)
+	oldestAllowed := a.oldestAllowed()
 	for userID, msg := range cstandup {
 		if msg.ts.Before(oldestAllowed) {
 			delete(cstandup, userID)
+			if err := a.store.Delete(scopeID, userID); err != nil {
+				logrus.Errorf("Can't delete stale standup for user %s in scope %s: %s", userID, scopeID, err)
+			}
 		}
 	}
 }
 
-func (a arriba) prettyPrintChannelStandup(cstandup channelStandup) string {
-	text := "¡Ándale! ¡Ándale! here's the standup status :tada:\n"
-	for _, userID := range cstandup.getKeysByTimestamp() {
-		standupMsg := cstandup[userID]
-		humanTime := humanize.Time(standupMsg.ts)
-		userName := a.getUserName(userID)
-		// Inject zero-width unicode character in username to avoid notifying users
-		if len(userName) > 1 {
-			userName = string(userName[0]) + "\ufeff" + string(userName[1:])
-		}
-		text += fmt.Sprintf("*%s*: %s _(%s)_\n", userName, standupMsg.text, humanTime)
+// standupTarget identifies an independent standup (scopeID, used to key
+// a.standups and the store) and where replies about it should be posted
+// (channelID plus, for thread-scoped standups, threadTimestamp).
+type standupTarget struct {
+	scopeID         string
+	channelID       string
+	threadTimestamp string
+}
+
+// newStandupTarget builds the target for a message in channelID. Messages
+// posted inside a thread get their own scope, so a channel can run several
+// independent standups in parallel (e.g. one per squad) without flooding
+// the top level.
+func newStandupTarget(channelID, threadTimestamp string) standupTarget {
+	scopeID := channelID
+	if threadTimestamp != "" {
+		scopeID = channelID + ":" + threadTimestamp
 	}
-	return text
+	return standupTarget{scopeID: scopeID, channelID: channelID, threadTimestamp: threadTimestamp}
 }
 
-func (a arriba) sendStatus(channelID string) {
-	var statusText string
-	if cstandup, ok := a.standups[channelID]; ok && len(cstandup) > 0 {
-		statusText = a.prettyPrintChannelStandup(cstandup)
-	} else {
-		statusText = fmt.Sprintf("No standup messages found\nType a message starting with *@%s* to record your standup message", a.botName)
+// send posts text to target via the Web API, which works the same way
+// regardless of which transport delivered the triggering event. Replies to
+// a thread-scoped standup are posted back into that thread.
+func (a arriba) send(target standupTarget, text string) {
+	options := []slack.MsgOption{slack.MsgOptionText(text, false)}
+	if target.threadTimestamp != "" {
+		options = append(options, slack.MsgOptionTS(target.threadTimestamp))
+	}
+	if _, _, err := a.client.PostMessage(target.channelID, options...); err != nil {
+		logrus.Errorf("Can't post message to channel %s: %s", target.channelID, err)
 	}
-	a.rtm.SendMessage(a.rtm.NewOutgoingMessage(statusText, channelID))
+}
 
+// sendStatus posts the current standup status of target as a Block Kit
+// message, with buttons letting each user edit or remove their own entry.
+func (a arriba) sendStatus(target standupTarget) {
+	a.mu.Lock()
+	blocks := a.buildStandupBlocks(target, a.standups[target.scopeID])
+	a.mu.Unlock()
+	a.sendBlocks(target, blocks)
 }
 
-func (a arriba) updateLastStandup(channelID, userID string, msg standupMsg) {
-	if _, ok := a.standups[channelID]; !ok {
-		a.standups[channelID] = make(channelStandup)
+func (a arriba) updateLastStandup(target standupTarget, userID string, msg standupMsg) {
+	a.mu.Lock()
+	if _, ok := a.standups[target.scopeID]; !ok {
+		a.standups[target.scopeID] = make(channelStandup)
+	}
+	a.standups[target.scopeID][userID] = msg
+	err := a.store.Upsert(target.scopeID, userID, msg)
+	a.mu.Unlock()
+	if err != nil {
+		logrus.Errorf("Can't persist standup for user %s in scope %s: %s", userID, target.scopeID, err)
 	}
-	a.standups[channelID][userID] = msg
-	confirmationText := fmt.Sprintf("<@%s>: ¡Yeppa! standup status recorded :taco:", userID)
-	a.rtm.SendMessage(a.rtm.NewOutgoingMessage(confirmationText, channelID))
+	a.send(target, fmt.Sprintf("<@%s>: ¡Yeppa! standup status recorded :taco:", userID))
 }
 
-func (a *arriba) handleConnectedEvent(ev *slack.ConnectedEvent) {
+// handleConnected implements eventHandler: it's called once by the transport
+// as soon as the bot's identity and the conversations it belongs to are known.
+func (a *arriba) handleConnected(ctx context.Context, botID, botName string, conversations []conversation) {
 	if a.botID != "" {
 		logrus.Warn("Received unexpected Connected event")
 		return
 	}
-	logrus.Infof(
-		"Connected as user %s (%s) to team %s (%s)",
-		ev.Info.User.Name,
-		ev.Info.User.ID,
-		ev.Info.Team.Name,
-		ev.Info.Team.ID,
-	)
-	a.botID = ev.Info.User.ID
-	a.botName = ev.Info.User.Name
+	logrus.Infof("Connected as user %s (%s)", botName, botID)
+	a.botID = botID
+	a.botName = botName
 	a.extractMsgRE = regexp.MustCompile(fmt.Sprintf(extractMsgPattern, a.botID))
-
-	// Retrieve standups for public channels and private groups
-	var conversations []conversation
-	for _, c := range ev.Info.Channels {
-		if c.IsMember {
-			conversations = append(conversations, channel(c))
-		}
-	}
-	for _, g := range ev.Info.Groups {
-		conversations = append(conversations, group(g))
-	}
-	a.retrieveStandups(conversations)
+	a.retrieveStandups(ctx, conversations)
 }
 
-func (a arriba) handleMessageEvent(ev *slack.MessageEvent) {
-	logrus.Debugf("Message received %+v", ev)
+// handleMessage implements eventHandler: it's called by the transport for
+// every inbound message, whichever transport produced it.
+func (a arriba) handleMessage(msg inboundMessage) {
+	logrus.Debugf("Message received %+v", msg)
 	if a.botID == "" {
 		logrus.Warn("Received message event before finishing initialization")
 		return
 	}
-	if ev.Channel == "" {
+	if msg.channel == "" {
 		logrus.Warn("Received message with empty channel")
 		return
 	}
-	switch ev.Channel[0] {
+	switch msg.channel[0] {
 	case 'C', 'G':
 		// Public and private (group) channels
-		smsg, ok := a.extractChannelStandupMsg(ev.Msg)
+		smsg, ok := a.extractStandupMsg(msg)
 		if !ok {
 			return
 		}
-		logrus.Infof("Received standup message in channel %s: %+v", ev.Channel, smsg)
+		logrus.Infof("Received standup message in channel %s: %+v", msg.channel, smsg)
+		target := newStandupTarget(msg.channel, msg.threadTimestamp)
 		// Garbage-collect old messages
-		a.removeOldMessages(ev.Msg.Channel)
+		a.removeOldMessages(target.scopeID)
 		if smsg.text == "" {
-			a.sendStatus(ev.Msg.Channel)
+			a.sendStatus(target)
 		} else {
-			a.updateLastStandup(ev.Msg.Channel, ev.Msg.User, smsg)
+			a.updateLastStandup(target, msg.user, smsg)
 		}
 
 	case 'D':
-		// Direct messages are not supported yet
-	}
-}
-
-func (a arriba) run() {
-	go a.rtm.ManageConnection()
-
-	for {
-		select {
-		case msg := <-a.rtm.IncomingEvents:
-			switch ev := msg.Data.(type) {
-			case *slack.ConnectedEvent:
-				a.handleConnectedEvent(ev)
-			case *slack.MessageEvent:
-				a.handleMessageEvent(ev)
-			case *slack.RTMError:
-				logrus.Error("Invalid credentials", ev.Error())
-			case *slack.InvalidAuthEvent:
-				logrus.Error("Invalid credentials")
-				os.Exit(1)
-			}
-		}
+		a.handleDirectMessage(msg)
 	}
 }
 
@@ -333,11 +376,25 @@ func main() {
 	var (
 		debug            bool
 		historyDaysLimit int
+		storeSpec        string
+		transportKind    string
+		appToken         string
+		signingSecret    string
+		httpAddr         string
+		homeChannel      string
+		configPath       string
 	)
 
 	flag.Usage = usage
 	flag.BoolVar(&debug, "debug", false, "Print debug information")
 	flag.IntVar(&historyDaysLimit, "history-limit", 7, "History limit (in days)")
+	flag.StringVar(&storeSpec, "store", "memory", `Standup storage backend: "memory" or "bolt:<path>"`)
+	flag.StringVar(&transportKind, "transport", "rtm", "Slack transport: rtm, socket or events")
+	flag.StringVar(&appToken, "app-token", "", "Slack app-level token (xapp-...), required for -transport=socket")
+	flag.StringVar(&signingSecret, "signing-secret", "", "Slack signing secret, required for -transport=events")
+	flag.StringVar(&httpAddr, "http-addr", ":3000", "Address to listen on for -transport=events")
+	flag.StringVar(&homeChannel, "home-channel", "", "Channel DMed standups are recorded against (otherwise the user is asked to pick one)")
+	flag.StringVar(&configPath, "config", "", "YAML file with per-channel reminder/digest cron schedules")
 	flag.Parse()
 	if len(flag.Args()) < 1 || historyDaysLimit < 1 {
 		usage()
@@ -349,8 +406,41 @@ func main() {
 		logrus.SetLevel(logrus.DebugLevel)
 	}
 
-	api := slack.New(flag.Arg(0))
-	api.SetDebug(debug)
+	store, err := newStandupStore(storeSpec)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	defer store.Close()
+
+	clientOpts := []slack.Option{slack.OptionDebug(debug)}
+	if transportKind == "socket" {
+		clientOpts = append(clientOpts, slack.OptionAppLevelToken(appToken))
+	}
+	client := slack.New(flag.Arg(0), clientOpts...)
+
+	tr, err := newTransport(transportKind, client, transportOptions{
+		appToken:      appToken,
+		signingSecret: signingSecret,
+		addr:          httpAddr,
+	})
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
-	newArriba(api.NewRTM(), historyDaysLimit).run()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := newArriba(tr.client(), historyDaysLimit, store, homeChannel)
+	if configPath != "" {
+		sched := newScheduler(&a)
+		if err := loadSchedules(ctx, configPath, sched); err != nil {
+			logrus.Fatal(err)
+		}
+		a.scheduler = sched
+		go sched.run(ctx)
+	}
+
+	if err := tr.run(ctx, &a); err != nil {
+		logrus.Fatal(err)
+	}
 }
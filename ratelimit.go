@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"golang.org/x/time/rate"
+)
+
+// slackLimiter throttles outgoing Slack Web API calls so arriba doesn't trip
+// Slack's own rate limits; it's shared by every conversations.* call arriba
+// makes, regardless of which conversation or transport triggered it.
+var slackLimiter = rate.NewLimiter(rate.Every(time.Second), 1)
+
+// withRateLimit waits for the shared limiter and then calls fn. If fn fails
+// with a Slack rate-limit error, it honors the Retry-After the API returned
+// (falling back to exponential backoff if Slack didn't provide one) and
+// retries until it succeeds, fn returns a different error, or ctx is done.
+func withRateLimit(ctx context.Context, fn func() error) error {
+	backoff := time.Second
+	for {
+		if err := slackLimiter.Wait(ctx); err != nil {
+			return err
+		}
+
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		rateLimitedErr, ok := err.(*slack.RateLimitedError)
+		if !ok {
+			return err
+		}
+
+		wait := rateLimitedErr.RetryAfter
+		if wait <= 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		logrus.Warnf("Rate limited by Slack, retrying in %s", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/slack-go/slack"
+)
+
+// schedule is a channel's standup cadence: reminderSchedule fires a nudge
+// for every member who hasn't posted since the last tick, digestSchedule
+// posts the channel's consolidated standup status.
+type schedule struct {
+	channelID        string
+	reminderCron     string
+	digestCron       string
+	reminderSchedule cron.Schedule
+	digestSchedule   cron.Schedule
+	members          []string
+	nextReminder     time.Time
+	nextDigest       time.Time
+	lastReminder     time.Time
+}
+
+// newSchedule parses reminderCron/digestCron and anchors their first run at now.
+func newSchedule(channelID, reminderCron, digestCron string, members []string, now time.Time) (*schedule, error) {
+	reminderSchedule, err := cron.ParseStandard(reminderCron)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reminder cron %q: %s", reminderCron, err)
+	}
+	digestSchedule, err := cron.ParseStandard(digestCron)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest cron %q: %s", digestCron, err)
+	}
+	return &schedule{
+		channelID:        channelID,
+		reminderCron:     reminderCron,
+		digestCron:       digestCron,
+		reminderSchedule: reminderSchedule,
+		digestSchedule:   digestSchedule,
+		members:          members,
+		nextReminder:     reminderSchedule.Next(now),
+		nextDigest:       digestSchedule.Next(now),
+		lastReminder:     now,
+	}, nil
+}
+
+// scheduler ticks once a minute, firing the reminder or digest of every
+// configured channel whose cron expression is due.
+type scheduler struct {
+	arriba *arriba
+
+	mu        sync.Mutex
+	schedules map[string]*schedule // keyed by channelID
+}
+
+func newScheduler(a *arriba) *scheduler {
+	return &scheduler{arriba: a, schedules: make(map[string]*schedule)}
+}
+
+// addSchedule installs (or replaces) the schedule for a channel, resolving
+// its membership from conversations.members.
+func (s *scheduler) addSchedule(ctx context.Context, channelID, reminderCron, digestCron string) error {
+	members, err := conversationMembers(ctx, s.arriba.client, channelID)
+	if err != nil {
+		return fmt.Errorf("can't list members of channel %s: %s", channelID, err)
+	}
+	sc, err := newSchedule(channelID, reminderCron, digestCron, members, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.schedules[channelID] = sc
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *scheduler) removeSchedule(channelID string) {
+	s.mu.Lock()
+	delete(s.schedules, channelID)
+	s.mu.Unlock()
+}
+
+// run ticks every minute until ctx is done.
+func (s *scheduler) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.tick(now.UTC())
+		}
+	}
+}
+
+func (s *scheduler) tick(now time.Time) {
+	s.mu.Lock()
+	due := make([]*schedule, 0, len(s.schedules))
+	for _, sc := range s.schedules {
+		due = append(due, sc)
+	}
+	s.mu.Unlock()
+
+	for _, sc := range due {
+		if !now.Before(sc.nextReminder) {
+			s.remind(sc, now)
+			sc.nextReminder = sc.reminderSchedule.Next(now)
+		}
+		if !now.Before(sc.nextDigest) {
+			s.arriba.sendStatus(newStandupTarget(sc.channelID, ""))
+			sc.nextDigest = sc.digestSchedule.Next(now)
+		}
+	}
+}
+
+// remind nudges every member of sc who hasn't posted a standup since the
+// last reminder tick.
+func (s *scheduler) remind(sc *schedule, now time.Time) {
+	s.arriba.mu.Lock()
+	cstandup := s.arriba.standups[sc.channelID]
+	stale := make([]string, 0, len(sc.members))
+	for _, userID := range sc.members {
+		if msg, ok := cstandup[userID]; ok && !msg.ts.Before(sc.lastReminder) {
+			continue
+		}
+		stale = append(stale, userID)
+	}
+	s.arriba.mu.Unlock()
+
+	for _, userID := range stale {
+		s.arriba.send(newStandupTarget(sc.channelID, ""), fmt.Sprintf(
+			"<@%s>: don't forget to post your standup! Message me with *@%s* followed by your update.",
+			userID, s.arriba.botName))
+	}
+	sc.lastReminder = now
+}
+
+// conversationMembers lists every member of channelID via
+// conversations.members, paginating the same way listConversations does.
+func conversationMembers(ctx context.Context, client *slack.Client, channelID string) ([]string, error) {
+	var members []string
+	cursor := ""
+	for {
+		var page []string
+		var nextCursor string
+		err := withRateLimit(ctx, func() error {
+			var err error
+			page, nextCursor, err = client.GetUsersInConversationContext(ctx, &slack.GetUsersInConversationParameters{
+				ChannelID: channelID,
+				Cursor:    cursor,
+				Limit:     200,
+			})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, page...)
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+	return members, nil
+}
+
+// handleSlashCommand implements eventHandler: it lets admins manage
+// schedules via "/arriba schedule <channel> <reminder-cron> <digest-cron>"
+// and "/arriba schedule remove <channel>", without a redeploy.
+func (a arriba) handleSlashCommand(ctx context.Context, cmd slack.SlashCommand) string {
+	const usage = "Usage: /arriba schedule <channel> <reminder-cron> <digest-cron> | /arriba schedule remove <channel>"
+	if a.scheduler == nil {
+		return "Scheduling isn't configured (start arriba with -config to enable it)."
+	}
+	fields := strings.Fields(cmd.Text)
+	if len(fields) == 0 || fields[0] != "schedule" {
+		return usage
+	}
+	fields = fields[1:]
+	if len(fields) == 2 && fields[0] == "remove" {
+		a.scheduler.removeSchedule(fields[1])
+		return fmt.Sprintf("Removed the schedule for %s.", fields[1])
+	}
+	if len(fields) != 3 {
+		return usage
+	}
+	channelID, reminderCron, digestCron := fields[0], fields[1], fields[2]
+	if err := a.scheduler.addSchedule(ctx, channelID, reminderCron, digestCron); err != nil {
+		return fmt.Sprintf("Can't set up schedule: %s", err)
+	}
+	return fmt.Sprintf("Scheduled %s: reminders %q, digest %q.", channelID, reminderCron, digestCron)
+}
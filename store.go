@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// StandupStore persists channel standups across restarts, so a dropped
+// websocket connection (or a plain restart) doesn't force arriba to rescan
+// the whole Slack history again.
+type StandupStore interface {
+	// LoadAll returns every standup known to the store, keyed by channel.
+	LoadAll() (standups, error)
+	// Upsert records (or replaces) the latest standup message of a user in a channel.
+	Upsert(channelID, userID string, msg standupMsg) error
+	// Delete removes a user's standup message from a channel.
+	Delete(channelID, userID string) error
+	// PurgeOlderThan removes every standup message older than t.
+	PurgeOlderThan(t time.Time) error
+	// Close releases any resources held by the store.
+	Close() error
+}
+
+// newStandupStore builds a StandupStore from a -store flag value, either
+// "memory" or "bolt:<path>".
+func newStandupStore(spec string) (StandupStore, error) {
+	switch {
+	case spec == "" || spec == "memory":
+		return newMemoryStore(), nil
+	case strings.HasPrefix(spec, "bolt:"):
+		return newBoltStore(strings.TrimPrefix(spec, "bolt:"))
+	default:
+		return nil, fmt.Errorf(`unknown store %q (expected "memory" or "bolt:<path>")`, spec)
+	}
+}
+
+// memoryStore is a StandupStore that keeps everything in memory. It never
+// touches disk, so it's mostly useful for tests and for running arriba
+// without the -store flag.
+type memoryStore struct {
+	standups standups
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{standups: make(standups)}
+}
+
+func (s *memoryStore) LoadAll() (standups, error) {
+	return s.standups, nil
+}
+
+func (s *memoryStore) Upsert(channelID, userID string, msg standupMsg) error {
+	if _, ok := s.standups[channelID]; !ok {
+		s.standups[channelID] = make(channelStandup)
+	}
+	s.standups[channelID][userID] = msg
+	return nil
+}
+
+func (s *memoryStore) Delete(channelID, userID string) error {
+	if cstandup, ok := s.standups[channelID]; ok {
+		delete(cstandup, userID)
+	}
+	return nil
+}
+
+func (s *memoryStore) PurgeOlderThan(t time.Time) error {
+	for _, cstandup := range s.standups {
+		for userID, msg := range cstandup {
+			if msg.ts.Before(t) {
+				delete(cstandup, userID)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Close() error { return nil }
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+)
+
+// inboundMessage is arriba's own, transport-agnostic view of a Slack message
+// event: RTM, Socket Mode and the HTTP Events API each hand us a differently
+// shaped event, and this is what they get normalized into before reaching
+// the handler.
+type inboundMessage struct {
+	channel         string
+	user            string
+	text            string
+	ts              string
+	threadTimestamp string
+	subtype         string
+}
+
+// eventHandler receives the events arriba cares about, independently of the
+// transport that produced them.
+type eventHandler interface {
+	// handleConnected is called once, as soon as the bot's identity and the
+	// conversations it belongs to are known.
+	handleConnected(ctx context.Context, botID, botName string, conversations []conversation)
+	// handleMessage is called for every inbound message.
+	handleMessage(msg inboundMessage)
+	// handleInteraction is called for every interactive message payload
+	// (e.g. a button click on a message arriba posted).
+	handleInteraction(payload slack.InteractionCallback)
+	// handleSlashCommand is called for every slash command invocation and
+	// returns the (ephemeral) response text.
+	handleSlashCommand(ctx context.Context, cmd slack.SlashCommand) string
+}
+
+// transport delivers Slack events to an eventHandler and exposes the
+// *slack.Client used for Web API calls, regardless of whether the
+// underlying connection is classic RTM, Socket Mode, or the HTTP Events API.
+type transport interface {
+	// run starts the transport and blocks, dispatching events to handler
+	// until ctx is cancelled or an unrecoverable error occurs.
+	run(ctx context.Context, handler eventHandler) error
+	// client returns the *slack.Client backing this transport.
+	client() *slack.Client
+}
+
+// transportOptions carries the flags relevant to socket/events transports;
+// rtm doesn't need any of them.
+type transportOptions struct {
+	appToken      string
+	signingSecret string
+	addr          string
+}
+
+// newTransport builds the transport selected by -transport.
+func newTransport(kind string, client *slack.Client, opts transportOptions) (transport, error) {
+	switch kind {
+	case "", "rtm":
+		return &rtmTransport{api: client}, nil
+	case "socket":
+		if opts.appToken == "" {
+			return nil, fmt.Errorf("-transport=socket requires -app-token (an xapp- level token)")
+		}
+		return &socketTransport{api: client}, nil
+	case "events":
+		if opts.signingSecret == "" {
+			return nil, fmt.Errorf("-transport=events requires -signing-secret")
+		}
+		addr := opts.addr
+		if addr == "" {
+			addr = ":3000"
+		}
+		return &eventsTransport{api: client, signingSecret: opts.signingSecret, addr: addr}, nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q (expected rtm, socket or events)", kind)
+	}
+}
+
+// listConversations enumerates the public and private channels the bot is a
+// member of via the modern conversations.list endpoint, following its
+// cursor-based pagination and honoring Slack's rate limits.
+func listConversations(ctx context.Context, client *slack.Client) ([]conversation, error) {
+	var conversations []conversation
+	cursor := ""
+	for {
+		var chans []slack.Channel
+		var nextCursor string
+		err := withRateLimit(ctx, func() error {
+			var err error
+			chans, nextCursor, err = client.GetConversationsContext(ctx, &slack.GetConversationsParameters{
+				Types:  []string{"public_channel", "private_channel"},
+				Cursor: cursor,
+				Limit:  200,
+			})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range chans {
+			if c.IsMember {
+				conversations = append(conversations, conversation{c})
+			}
+		}
+
+		if nextCursor == "" {
+			break
+		}
+		cursor = nextCursor
+	}
+	return conversations, nil
+}
+
+// bootstrap authenticates, lists the bot's conversations and hands both to
+// handler. It's the same dance every transport performs once it's up.
+func bootstrap(ctx context.Context, api *slack.Client, handler eventHandler) {
+	auth, err := api.AuthTest()
+	if err != nil {
+		logrus.Errorf("Can't authenticate: %s", err)
+		return
+	}
+	conversations, err := listConversations(ctx, api)
+	if err != nil {
+		logrus.Errorf("Can't list conversations: %s", err)
+	}
+	handler.handleConnected(ctx, auth.UserID, auth.User, conversations)
+}
+
+// dispatchInnerEvent converts the Events API inner event into an
+// inboundMessage and hands it to handler, covering message.channels,
+// message.groups, message.im and app_mention.
+func dispatchInnerEvent(inner slackevents.EventsAPIInnerEvent, handler eventHandler) {
+	switch ev := inner.Data.(type) {
+	case *slackevents.MessageEvent:
+		handler.handleMessage(inboundMessage{
+			channel:         ev.Channel,
+			user:            ev.User,
+			text:            ev.Text,
+			ts:              ev.TimeStamp,
+			threadTimestamp: ev.ThreadTimeStamp,
+			subtype:         ev.SubType,
+		})
+	case *slackevents.AppMentionEvent:
+		handler.handleMessage(inboundMessage{
+			channel:         ev.Channel,
+			user:            ev.User,
+			text:            ev.Text,
+			ts:              ev.TimeStamp,
+			threadTimestamp: ev.ThreadTimeStamp,
+		})
+	}
+}
+
+// rtmTransport is the legacy transport, kept for teams that haven't migrated
+// their bot token to a modern app yet.
+type rtmTransport struct {
+	api *slack.Client
+}
+
+func (t *rtmTransport) client() *slack.Client { return t.api }
+
+func (t *rtmTransport) run(ctx context.Context, handler eventHandler) error {
+	rtm := t.api.NewRTM()
+	go rtm.ManageConnection()
+	defer rtm.Disconnect()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-rtm.IncomingEvents:
+			switch ev := msg.Data.(type) {
+			case *slack.ConnectedEvent:
+				// The connected event's channel/group snapshot is what we
+				// used to seed from, but conversations.list (via bootstrap)
+				// paginates properly and is shared with the other transports.
+				bootstrap(ctx, t.api, handler)
+			case *slack.MessageEvent:
+				handler.handleMessage(inboundMessage{
+					channel:         ev.Channel,
+					user:            ev.User,
+					text:            ev.Text,
+					ts:              ev.Timestamp,
+					threadTimestamp: ev.ThreadTimestamp,
+					subtype:         ev.SubType,
+				})
+			case *slack.RTMError:
+				logrus.Error("RTM error: ", ev.Error())
+			case *slack.InvalidAuthEvent:
+				return fmt.Errorf("invalid credentials")
+			}
+		}
+	}
+}
+
+// socketTransport runs arriba as a modern Slack app over Socket Mode, which
+// needs only an app-level token and no public HTTP endpoint.
+type socketTransport struct {
+	api *slack.Client
+}
+
+func (t *socketTransport) client() *slack.Client { return t.api }
+
+func (t *socketTransport) run(ctx context.Context, handler eventHandler) error {
+	sm := socketmode.New(t.api)
+
+	go func() {
+		for evt := range sm.Events {
+			switch evt.Type {
+			case socketmode.EventTypeConnected:
+				bootstrap(ctx, t.api, handler)
+			case socketmode.EventTypeEventsAPI:
+				eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent)
+				if !ok {
+					continue
+				}
+				if evt.Request != nil {
+					sm.Ack(*evt.Request)
+				}
+				dispatchInnerEvent(eventsAPIEvent.InnerEvent, handler)
+			case socketmode.EventTypeInteractive:
+				callback, ok := evt.Data.(slack.InteractionCallback)
+				if !ok {
+					continue
+				}
+				if evt.Request != nil {
+					sm.Ack(*evt.Request)
+				}
+				handler.handleInteraction(callback)
+			case socketmode.EventTypeSlashCommand:
+				cmd, ok := evt.Data.(slack.SlashCommand)
+				if !ok {
+					continue
+				}
+				response := handler.handleSlashCommand(ctx, cmd)
+				if evt.Request != nil {
+					sm.Ack(*evt.Request, map[string]string{"response_type": "ephemeral", "text": response})
+				}
+			}
+		}
+	}()
+
+	return sm.RunContext(ctx)
+}
+
+// eventsTransport runs arriba behind a public HTTP endpoint that Slack posts
+// Events API callbacks to, verified via the app's signing secret.
+type eventsTransport struct {
+	api           *slack.Client
+	signingSecret string
+	addr          string
+}
+
+func (t *eventsTransport) client() *slack.Client { return t.api }
+
+func (t *eventsTransport) run(ctx context.Context, handler eventHandler) error {
+	bootstrap(ctx, t.api, handler)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slack/events", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		verifier, err := slack.NewSecretsVerifier(r.Header, t.signingSecret)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if _, werr := verifier.Write(body); werr != nil || verifier.Ensure() != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		event, err := slackevents.ParseEvent(body, slackevents.OptionNoVerifyToken())
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch event.Type {
+		case slackevents.URLVerification:
+			var challenge slackevents.ChallengeResponse
+			if err := json.Unmarshal(body, &challenge); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", "text")
+			w.Write([]byte(challenge.Challenge))
+		case slackevents.CallbackEvent:
+			dispatchInnerEvent(event.InnerEvent, handler)
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	})
+	mux.HandleFunc("/slack/actions", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		verifier, err := slack.NewSecretsVerifier(r.Header, t.signingSecret)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if _, werr := verifier.Write(body); werr != nil || verifier.Ensure() != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var callback slack.InteractionCallback
+		if err := json.Unmarshal([]byte(values.Get("payload")), &callback); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		handler.handleInteraction(callback)
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/slack/commands", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		verifier, err := slack.NewSecretsVerifier(r.Header, t.signingSecret)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if _, werr := verifier.Write(body); werr != nil || verifier.Ensure() != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		cmd := slack.SlashCommand{
+			Command:   values.Get("command"),
+			Text:      values.Get("text"),
+			ChannelID: values.Get("channel_id"),
+			UserID:    values.Get("user_id"),
+		}
+		response := handler.handleSlashCommand(r.Context(), cmd)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"response_type": "ephemeral", "text": response})
+	})
+
+	server := &http.Server{Addr: t.addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	logrus.Infof("Listening for Slack events on %s", t.addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}